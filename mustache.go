@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"os"
 	"path"
+	"sync"
 )
 
 /*
@@ -47,14 +48,39 @@ template inheritance:
 
 block element:
 	{{*block_name}}
+
+super call, inside an overriding block:
+	{{$super}}
+	{{*super}}
+
+set delimiter, changes the tag delimiters from {{ }} to whatever follows,
+useful for embedding mustache inside a host language that already uses
+{{ }} for its own syntax (e.g. LaTeX, Vue templates):
+	{{=<% %>=}}
+the new delimiters apply until the end of the enclosing section/block,
+after which the previous delimiters resume
 */
 
 type element interface {
 	Type() ElementType
-	Render(writer io.Writer, contextChain []interface{})
+	Render(writer io.Writer, contextChain []interface{}, rs *renderState) error
 	String() string
 }
 
+// renderState carries the per-render options that need to reach deeply
+// nested elements: the formatter pipeline overrides, the stack of
+// ancestor block bodies a {{$super}}/{{*super}} tag can fall back to,
+// and whether an unresolved name is a render error or silently empty.
+// It's built once per Render/Execute call and threaded down unchanged,
+// except that a block override is rendered with supers set to the
+// ancestor bodies it overrides, and a super tag renders the nearest of
+// those with it taken off.
+type renderState struct {
+	formatters FormatterMap
+	supers []*blockElement // nearest ancestor override last; empty outside a block override
+	strict bool            // if true, a name that resolves to nothing is a RenderError instead of blank output
+}
+
 type container interface{
 	element
 	addChild(child element)
@@ -73,6 +99,7 @@ const (
 	sectionType
 	templateType
 	blockType
+	superType
 )
 
 
@@ -81,35 +108,105 @@ type textElement struct {
 	text []byte
 }
 
-func (el *textElement) Render(writer io.Writer, contextChain []interface{}) {
-	writer.Write(el.text)
+func (el *textElement) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
+	_, err := writer.Write(el.text)
+	return err
 }
 
 func (el *textElement) String() string{
 	return fmt.Sprintf("Text{ %q }", el.text)
 }
 
+// FormatterMap holds named output formatters, each responsible for
+// writing a value to the output writer. It plays the same role as
+// text/template's FuncMap, but for the `{{name|formatter}}` pipeline
+// syntax instead of full template functions.
+type FormatterMap map[string]func(io.Writer, interface{})
+
+// HtmlFormatter writes val HTML-escaped; it is the default formatter
+// used for "" and "html".
+func HtmlFormatter(writer io.Writer, val interface{}) {
+	fmt.Fprint(writer, html.EscapeString(fmt.Sprint(val)))
+}
+
+// StringFormatter writes val with no escaping at all.
+func StringFormatter(writer io.Writer, val interface{}) {
+	fmt.Fprint(writer, fmt.Sprint(val))
+}
+
+// RegisterFormatter is the package-wide default FormatterMap, consulted
+// whenever a Template has no override of its own for a given name.
+// Replacing the "" entry changes the formatter used for {{name}} tags
+// that don't specify a pipeline.
+var RegisterFormatter = FormatterMap{
+	"":     HtmlFormatter,
+	"html": HtmlFormatter,
+	"str":  StringFormatter,
+}
+
+func resolveFormatter(name string, local FormatterMap) func(io.Writer, interface{}) {
+	if local != nil {
+		if f, ok := local[name]; ok {
+			return f
+		}
+	}
+	if f, ok := RegisterFormatter[name]; ok {
+		return f
+	}
+	return nil
+}
+
+func runFormatterPipeline(writer io.Writer, val interface{}, names []string, local FormatterMap) {
+	cur := val
+	for i, name := range names {
+		f := resolveFormatter(name, local)
+		if f == nil {
+			f = HtmlFormatter
+		}
+		if i == len(names)-1 {
+			f(writer, cur)
+			return
+		}
+		var buf bytes.Buffer
+		f(&buf, cur)
+		cur = buf.String()
+	}
+}
+
 type variableElement struct {
 	ElementType
 	name string
 	raw bool
+	formatters []string // pipeline of formatter names from {{name|fmt1|fmt2}}
 }
 
-func (el *variableElement) Render(writer io.Writer, contextChain []interface{}) {
+func (el *variableElement) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
 	val := lookup(el.name, contextChain)
-	if val.IsValid(){
-		fmt.Println("raw", el.raw)
-		if el.raw{
-			fmt.Fprint(writer, val.Interface())
-		}else{
-			s := fmt.Sprint(val.Interface())
-			fmt.Fprint(writer, html.EscapeString(s))
+	if !val.IsValid(){
+		if rs != nil && rs.strict {
+			return &RenderError{Path: []string{el.name}, Cause: fmt.Errorf("%q not found in context", el.name)}
+		}
+		return nil
+	}
+	if val.Kind() == reflect.Func {
+		if out, ok := callVarLambda(val, contextChain); ok {
+			val = reflect.ValueOf(out)
 		}
 	}
+	if el.raw{
+		fmt.Fprint(writer, val.Interface())
+		return nil
+	}
+	names := el.formatters
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	runFormatterPipeline(writer, val.Interface(), names, rs.formatters)
+	return nil
 }
 
 func (el *variableElement) String() string{
-	return fmt.Sprintf("Variable{ name=%s, raw=%v }", el.name, el.raw)
+	return fmt.Sprintf("Variable{ name=%s, raw=%v, formatters=%v }", el.name, el.raw, el.formatters)
 }
 
 type sectionElement struct {
@@ -117,6 +214,7 @@ type sectionElement struct {
 	name string
 	inverted bool
 	elements []element
+	text []byte // raw, unparsed inner template text, used for lambda dispatch
 }
 
 func (el *sectionElement) String() string{
@@ -131,15 +229,27 @@ func (el *sectionElement) getName() string{
 	return el.name
 }
 
-func (section *sectionElement) Render(writer io.Writer, contextChain []interface{}) {
+func (section *sectionElement) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
 	val := lookup(section.name, contextChain)
 
+	if !val.IsValid() && rs != nil && rs.strict {
+		return &RenderError{Path: []string{section.name}, Cause: fmt.Errorf("%q not found in context", section.name)}
+	}
+
 	var ctxs = []interface{}{}
 	// if the value is nil, check if it's an inverted section
 	isTrue := isTrue(val)
 	if !isTrue && !section.inverted || isTrue && section.inverted {
-		return
+		return nil
 	} else {
+		if val.Kind() == reflect.Func {
+			if out, ok := callSectionLambda(val, string(section.text), contextChain); ok {
+				if !section.inverted {
+					fmt.Fprint(writer, out)
+				}
+				return nil
+			}
+		}
 		switch val.Kind() {
 		case reflect.Slice:
 			for i := 0; i < val.Len(); i++ {
@@ -165,9 +275,12 @@ func (section *sectionElement) Render(writer io.Writer, contextChain []interface
 	for _, ctx := range ctxs {
 		chain[0] = ctx
 		for _, el := range section.elements {
-			el.Render(writer, chain)
+			if err := el.Render(writer, chain, rs); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
 
@@ -189,111 +302,281 @@ func (el *blockElement) getName() string{
 	return el.name
 }
 
-func (el *blockElement) Render(writer io.Writer, contextChain []interface{}) {
-	for _, el := range el.elements {
-		el.Render(writer, contextChain)
+func (el *blockElement) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
+	for _, child := range el.elements {
+		if err := child.Render(writer, contextChain, rs); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
+// superElement implements {{$super}} / {{*super}}: rendered inside an
+// overriding block, it renders the next-nearest ancestor's body for
+// that same block name, taken off rs.supers. If there is no such
+// ancestor (the block isn't overriding anything, or the chain is
+// exhausted) it renders nothing.
+type superElement struct {
+	ElementType
+}
 
+func (el *superElement) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
+	if rs == nil || len(rs.supers) == 0 {
+		return nil
+	}
+	ancestors := rs.supers
+	parent := ancestors[len(ancestors)-1]
+	return parent.Render(writer, contextChain, &renderState{formatters: rs.formatters, strict: rs.strict, supers: ancestors[:len(ancestors)-1]})
+}
+
+func (el *superElement) String() string{
+	return "Super{}"
+}
+
+
+// Template is the immutable, parsed form of a mustache document. Once
+// parsed it holds no mutable parse state, so the same *Template can be
+// rendered concurrently from multiple goroutines.
 type Template struct {
 	ElementType
-	data []byte
-	leftToken string
-	rightToken string
-	pos int
-	line int
 	dir string                         // template file dir
+	ext string                         // file extension
 	elements []element                 // children elements
 	blocks map[string]*blockElement    // blcok map
-	ext string                         // file extension
 	parent string                      // parent template name
+	formatters FormatterMap            // per-template formatter overrides, see Funcs
+	strict bool                        // if true, an unresolved name is a RenderError instead of blank output, see Strict
+	set *TemplateSet                   // owning set, used to resolve partials/parent by name; nil if parsed standalone
 }
 
 func (el *Template) String() string{
 	return fmt.Sprintf("\nTemplate{elements=%v,\n blocks=%v,\n parent=%v}\n", el.elements, el.blocks, el.parent)
 }
 
-func (el *Template) addChild(child element){
-	el.elements = append(el.elements, child)
+// Funcs registers fm as formatter overrides for tmpl, following the same
+// fluent pattern as text/template's Funcs: entries here take priority
+// over RegisterFormatter when resolving a {{name|formatter}} pipeline.
+// Call it right after parsing, before the template is shared across
+// goroutines or rendered.
+func (tmpl *Template) Funcs(fm FormatterMap) *Template {
+	if tmpl.formatters == nil {
+		tmpl.formatters = FormatterMap{}
+	}
+	for name, f := range fm {
+		tmpl.formatters[name] = f
+	}
+	return tmpl
 }
 
-func (el *Template) getName() string{
-	return "template"
+// Strict sets whether rendering tmpl treats a name that resolves to
+// nothing as a RenderError instead of writing nothing. Off by default,
+// matching classic Mustache's "missing means empty" behavior.
+func (tmpl *Template) Strict(strict bool) *Template {
+	tmpl.strict = strict
+	return tmpl
 }
 
-func (tmpl *Template) Render(writer io.Writer, contextChain []interface{}) {
-	atmpl := tmpl
-	if tmpl.parent != "" {
-		tmpls := []*Template{tmpl}
-		
-		for{
-			if atmpl.parent != "" {
-				filename := path.Join(tmpl.dir, atmpl.parent + tmpl.ext)
-				parentTmpl, err := ParseFile(filename)
-				if err != nil{
-					panic(err)
-				}
-				tmpls = append(tmpls, parentTmpl)
-				atmpl = parentTmpl
-				
-			}else{
-				break
-			}
+// lookupTemplate resolves name to a parsed *Template, used for both
+// {{<parent}} and {{>partial}} lookups. When tmpl belongs to a
+// TemplateSet the lookup is cached there; otherwise it falls back to
+// parsing the file fresh every time, as a standalone Template has
+// nowhere to cache it.
+func (tmpl *Template) lookupTemplate(name string) (*Template, error) {
+	var t *Template
+	var err error
+	if tmpl.set != nil {
+		t, err = tmpl.set.ParseFile(name)
+	} else {
+		t, err = ParseFile(path.Join(tmpl.dir, name + tmpl.ext))
+	}
+	if err != nil {
+		switch err.(type) {
+		case *ParseError, *PartialNotFoundError:
+			// the file was found but malformed, or the lookup already
+			// reported a not-found error further down the chain: pass
+			// either through unchanged instead of re-wrapping it
+			return nil, err
+		default:
+			return nil, &PartialNotFoundError{Name: name, Err: err}
 		}
-		atmpl = tmpl
-		for i:= 1; i< len(tmpls); i++{
-			for _, block := range atmpl.blocks{
-				_, found := tmpls[i].blocks[block.getName()]
-				if found{
-					tmpls[i].blocks[block.getName()] = block
-				}
-			}
-			
-			atmpl = tmpls[i]
+	}
+	return t, nil
+}
+
+// effectiveTemplate walks the {{<parent}} chain (if any) and returns the
+// root ancestor to render, along with every block's full override
+// chain, oldest ancestor first and the most-derived override last. A
+// {{$super}}/{{*super}} inside the most-derived override steps back
+// through that chain one level at a time, so it can resolve to any
+// ancestor's body, not just the immediate parent's. The chain
+// templates themselves, including any shared via a TemplateSet, are
+// never mutated, so this is safe to call concurrently.
+func (tmpl *Template) effectiveTemplate() (*Template, map[string][]*blockElement, error) {
+	if tmpl.parent == "" {
+		blocks := make(map[string][]*blockElement, len(tmpl.blocks))
+		for name, block := range tmpl.blocks {
+			blocks[name] = []*blockElement{block}
 		}
-		atmpl = tmpls[len(tmpls)-1]
+		return tmpl, blocks, nil
+	}
+
+	chain := []*Template{tmpl}
+	for cur := tmpl; cur.parent != ""; {
+		parentTmpl, err := cur.lookupTemplate(cur.parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, parentTmpl)
+		cur = parentTmpl
+	}
+
+	blocks := map[string][]*blockElement{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for name, block := range chain[i].blocks {
+			blocks[name] = append(blocks[name], block)
+		}
+	}
+	return chain[len(chain)-1], blocks, nil
+}
+
+// renderBlock renders the most-derived override in chain, making every
+// earlier entry available to a {{$super}}/{{*super}} inside it.
+func renderBlock(writer io.Writer, contextChain []interface{}, rs *renderState, chain []*blockElement) error {
+	if len(chain) == 0 {
+		return nil
+	}
+	active := chain[len(chain)-1]
+	return active.Render(writer, contextChain, &renderState{formatters: rs.formatters, strict: rs.strict, supers: chain[:len(chain)-1]})
+}
+
+// Render renders tmpl against contextChain, resolving any {{<parent}}
+// chain and block overrides first. It implements the element interface
+// so a Template can itself appear as a {{>partial}} child of another
+// template. context-wide lookup or parent-resolution
+// failures are returned as a RenderError/PartialNotFoundError rather
+// than panicking; use Execute or RenderToString for convenience entry
+// points that don't require building a renderState by hand. rs may be
+// nil (e.g. a caller that doesn't have one to pass), in which case tmpl
+// renders with no formatter overrides and strict mode off.
+func (tmpl *Template) Render(writer io.Writer, contextChain []interface{}, rs *renderState) error {
+	if rs == nil {
+		rs = &renderState{}
 	}
-	
+
+	atmpl, blocks, err := tmpl.effectiveTemplate()
+	if err != nil {
+		return &RenderError{Path: []string{tmpl.parent}, Cause: err}
+	}
+
 	for _, el := range atmpl.elements {
 		if el.Type() != blockType{
-			el.Render(writer, contextChain)
+			if err := el.Render(writer, contextChain, rs); err != nil {
+				return err
+			}
 		}else{
-			atmpl.blocks[el.(*blockElement).getName()].Render(writer, contextChain)
+			if err := renderBlock(writer, contextChain, rs, blocks[el.(*blockElement).getName()]); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
+// RenderToString renders tmpl against contexts and returns the result,
+// panicking if rendering fails (e.g. an unresolvable {{<parent}} or, in
+// Strict mode, a missing name). Use Execute for an error return instead.
 func (tmpl *Template) RenderToString(contexts ...interface{}) string{
 	var buf bytes.Buffer
-	tmpl.Render(&buf, contexts)
+	if err := tmpl.Render(&buf, contexts, &renderState{formatters: tmpl.formatters, strict: tmpl.strict}); err != nil {
+		panic(err)
+	}
 	return buf.String()
 }
 
+// Execute renders tmpl against context and writes the result to writer,
+// returning an error instead of panicking when rendering fails.
+func (tmpl *Template) Execute(writer io.Writer, context ...interface{}) error {
+	return tmpl.Render(writer, context, &renderState{formatters: tmpl.formatters, strict: tmpl.strict})
+}
+
+// parser holds the mutable state needed only while parsing a single
+// document: the raw bytes, the scan position, and the in-progress
+// element/block tree. Only the finished tree, the parent name and the
+// dir/ext/strict/set settings are copied into the resulting Template;
+// the raw bytes, delimiters and scan position are not, which is why a
+// Template can be reused and rendered concurrently once parsing is done.
+type parser struct {
+	data []byte
+	leftToken string
+	rightToken string
+	pos int
+	line int
+	col int
+	file string                     // source filename, for ParseError; empty when parsed from a string
+	strict bool                     // strict default for templates parsed by this parser, see Template.Strict
+	dir string                      // template file dir
+	ext string                      // file extension
+	set *TemplateSet                // owning set, for resolving partials/parent by name
+	elements []element              // children elements
+	blocks map[string]*blockElement // blcok map
+	parent string                   // parent template name
+}
+
+func (p *parser) addChild(child element){
+	p.elements = append(p.elements, child)
+}
+
+func (p *parser) getName() string{
+	return "template"
+}
+
+// parseErr builds a ParseError positioned at the parser's current line
+// and column, identifying the offending tag text where one is known.
+func (p *parser) parseErr(tag, msg string) error {
+	return &ParseError{File: p.file, Line: p.line, Col: p.col, Tag: tag, Msg: msg}
+}
+
+func (p *parser) toTemplate() *Template {
+	return &Template{
+		ElementType: templateType,
+		dir: p.dir,
+		ext: p.ext,
+		elements: p.elements,
+		blocks: p.blocks,
+		parent: p.parent,
+		strict: p.strict,
+		set: p.set,
+	}
+}
+
 // goto the next token, and return the passed bytes.
-func (tmpl *Template) nextToken(token string) (text []byte, err error){
-	i := tmpl.pos
+func (p *parser) nextToken(token string) (text []byte, err error){
+	i := p.pos
 
 	for{
-		if i + len(token) > len(tmpl.data) {
-			return tmpl.data[tmpl.pos:], io.EOF
+		if i + len(token) > len(p.data) {
+			return p.data[p.pos:], io.EOF
 		}
-		
-		b := tmpl.data[i]
+
+		b := p.data[i]
 
 		if b == '\n' {
-			tmpl.line++
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
 		}
 
 		if b != token[0] {
 			i++
 			continue
 		}
-		
-		if bytes.HasPrefix(tmpl.data[i+1:], []byte(token[1:])){
+
+		if bytes.HasPrefix(p.data[i+1:], []byte(token[1:])){
 			//match
-			text := tmpl.data[tmpl.pos:i]
-			tmpl.pos = i + len(token)
+			text := p.data[p.pos:i]
+			p.pos = i + len(token)
+			p.col += len(token) - 1
 			return text, nil
 		}
 		i++
@@ -301,35 +584,35 @@ func (tmpl *Template) nextToken(token string) (text []byte, err error){
 	return []byte{}, nil
 }
 
-func (tmpl *Template) parse() error{
+func (p *parser) parse() error{
 	for{
-		text, err := tmpl.nextToken(tmpl.leftToken)
+		text, err := p.nextToken(p.leftToken)
 
 		if len(text) >0{
 			// add a text element
-			tmpl.addChild(&textElement{textType, text})
+			p.addChild(&textElement{textType, text})
 		}
-		
+
 		if err == io.EOF {
 			return nil
 		}
 
 		// prepare the next token
-		token := tmpl.rightToken
-		if tmpl.pos < len(tmpl.data) && tmpl.data[tmpl.pos] == '{' {
+		token := p.rightToken
+		if p.pos < len(p.data) && p.data[p.pos] == '{' {
 			// is raw variable element
-			token = tmpl.rightToken + "}"
+			token = p.rightToken + "}"
 		}
 
-		text, err = tmpl.nextToken(token)
+		text, err = p.nextToken(token)
 
 		if err == io.EOF{
-			return parseError{tmpl.line, "unmatched open tag"}
+			return p.parseErr("", "unmatched open tag")
 		}
 
 		text = bytes.TrimSpace(text)
 		if len(text) == 0{
-			return parseError{tmpl.line, "empty tag"}
+			return p.parseErr("", "empty tag")
 		}
 
 		// check the kind of element
@@ -337,84 +620,117 @@ func (tmpl *Template) parse() error{
 		case '!':
 			// ignore comment
 			break
+		case '=':
+			// set-delimiter tag: {{=NEW_LEFT NEW_RIGHT=}}
+			left, right, derr := parseDelims(text)
+			if derr != nil {
+				return p.parseErr(string(text), derr.Error())
+			}
+			p.leftToken = left
+			p.rightToken = right
 		case '#', '^':
 			// section element
-			if tmpl.parent != ""{
+			if p.parent != ""{
 				break
 			}
 			name := string(bytes.TrimSpace(text[1:]))
 
 			//ignore the new line when section start
-			if tmpl.pos < len(tmpl.data) && tmpl.data[tmpl.pos] == '\n'{
-				tmpl.pos += 1
-			}else if tmpl.pos+1 < len(tmpl.data) && tmpl.data[tmpl.pos] == '\r' && tmpl.data[tmpl.pos+1] == '\n'{
-				tmpl.pos += 2
+			if p.pos < len(p.data) && p.data[p.pos] == '\n'{
+				p.pos += 1
+			}else if p.pos+1 < len(p.data) && p.data[p.pos] == '\r' && p.data[p.pos+1] == '\n'{
+				p.pos += 2
 			}
 
-			section := &sectionElement{sectionType, name, text[0]=='^', []element{}}
+			section := &sectionElement{sectionType, name, text[0]=='^', []element{}, nil}
 
-			if err = tmpl.pareseContainer(section); err!=nil{
+			raw, err := p.pareseContainer(section)
+			if err != nil{
 				return err
 			}
+			section.text = raw
 
-			tmpl.addChild(section)
+			p.addChild(section)
 
 		case '{':
-			if tmpl.parent != ""{
+			if p.parent != ""{
 				break
 			}
 			// raw tag
-			tmpl.addChild(&variableElement{variableType, string(text[1:]), true})
+			p.addChild(&variableElement{variableType, string(text[1:]), true, nil})
 
 		case '>':
 			// partial
-			if tmpl.parent != ""{
+			if p.parent != ""{
 				break
 			}
 			name := string(bytes.TrimSpace(text[1:]))
-			partial, err := tmpl.parsePartial(name)
+			partial, err := p.parsePartial(name)
 			if err != nil {
 				return err
 			}
-			tmpl.addChild(partial)
+			p.addChild(partial)
 
 		case '<':
 			// parent template
 			name := string(bytes.TrimSpace(text[1:]))
-			tmpl.parent = name
+			p.parent = name
+
+		case '$':
+			// {{$super}}: render the overridden ancestor block here
+			p.addChild(&superElement{superType})
 
 		case '*':
 			// block element
 			name := string(bytes.TrimSpace(text[1:]))
 
+			if name == "super" {
+				// {{*super}}: alias for {{$super}}
+				p.addChild(&superElement{superType})
+				break
+			}
+
 			block := &blockElement{blockType, name, []element{}}
 
-			if err = tmpl.pareseContainer(block); err!=nil{
+			if _, err = p.pareseContainer(block); err!=nil{
 				return err
 			}
 
-			tmpl.addChild(block)
-			tmpl.blocks[name] = block
+			p.addChild(block)
+			p.blocks[name] = block
 
 		case '/':
-			return parseError{tmpl.line, "unmatched close tag"}
+			return p.parseErr(string(text), "unmatched close tag")
 		default:
-			if tmpl.parent != ""{
+			if p.parent != ""{
 				break
 			}
-			tmpl.addChild(&variableElement{variableType, string(text), false})
+			name, fmts := parseVariableTag(text)
+			p.addChild(&variableElement{variableType, name, false, fmts})
 		}
 	}
 	return nil
 }
 
 
-func (tmpl *Template) pareseContainer(el container) error{
+func (p *parser) pareseContainer(el container) ([]byte, error){
+	start := p.pos
+
+	// delimiters set inside this container (via {{=LEFT RIGHT=}}) must not
+	// leak out to whatever follows the container's closing tag
+	savedLeft, savedRight := p.leftToken, p.rightToken
+	defer func(){ p.leftToken, p.rightToken = savedLeft, savedRight }()
+
 	for{
-		text, err := tmpl.nextToken(tmpl.leftToken)
+		text, err := p.nextToken(p.leftToken)
+		// tagStart is where this iteration's tag left-delimiter begins; if
+		// the tag turns out to be the close tag, the raw inner text runs
+		// up to here, not up to the top of this iteration (which is
+		// before the text that precedes the close tag).
+		tagStart := p.pos - len(p.leftToken)
 
 		if err == io.EOF{
-			return parseError{tmpl.line, el.getName() + " has no closing tag"}
+			return nil, p.parseErr(el.getName(), "has no closing tag")
 		}
 
 		// add a text element
@@ -423,20 +739,20 @@ func (tmpl *Template) pareseContainer(el container) error{
 		}
 
 		// next token
-		token := tmpl.rightToken
-		if tmpl.pos < len(tmpl.data) && tmpl.data[tmpl.pos] == '{' {
+		token := p.rightToken
+		if p.pos < len(p.data) && p.data[p.pos] == '{' {
 			// is raw variable element
-			token = tmpl.rightToken + "}"
+			token = p.rightToken + "}"
 		}
 
-		text, err = tmpl.nextToken(token)
+		text, err = p.nextToken(token)
 		if err == io.EOF{
-			return parseError{tmpl.line, "unmatched open tag"}
+			return nil, p.parseErr("", "unmatched open tag")
 		}
 
 		text = bytes.TrimSpace(text)
 		if len(text) == 0{
-			return parseError{tmpl.line, "empty tag"}
+			return nil, p.parseErr("", "empty tag")
 		}
 
 		switch text[0]{
@@ -444,87 +760,182 @@ func (tmpl *Template) pareseContainer(el container) error{
 			// ignore
 			break
 
+		case '=':
+			// set-delimiter tag: {{=NEW_LEFT NEW_RIGHT=}}
+			left, right, derr := parseDelims(text)
+			if derr != nil {
+				return nil, p.parseErr(string(text), derr.Error())
+			}
+			p.leftToken = left
+			p.rightToken = right
+
 		case '#', '^':
 			// section element
 			name := string(bytes.TrimSpace(text[1:]))
 
 			//ignore the new line when section start
-			if tmpl.pos < len(tmpl.data) && tmpl.data[tmpl.pos] == '\n' {
-				tmpl.pos += 1
-			} else if tmpl.pos+1 < len(tmpl.data) && tmpl.data[tmpl.pos] == '\r' && tmpl.data[tmpl.pos+1] == '\n' {
-				tmpl.pos += 2
+			if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+				p.pos += 1
+			} else if p.pos+1 < len(p.data) && p.data[p.pos] == '\r' && p.data[p.pos+1] == '\n' {
+				p.pos += 2
 			}
 
-			sec := &sectionElement{sectionType, name, text[0]=='^', []element{}}
+			sec := &sectionElement{sectionType, name, text[0]=='^', []element{}, nil}
 
-			if err = tmpl.pareseContainer(sec); err!=nil{
-				return err
+			raw, err := p.pareseContainer(sec)
+			if err != nil{
+				return nil, err
 			}
-			
+			sec.text = raw
+
 			el.addChild(sec)
 
 		case '{':
 			// raw tag
-			el.addChild(&variableElement{variableType, string(text[1:]), true})
+			el.addChild(&variableElement{variableType, string(text[1:]), true, nil})
 
 		case '>':
 			// partial element
 			name := string(bytes.TrimSpace(text[1:]))
-			partial, err := tmpl.parsePartial(name)
+			partial, err := p.parsePartial(name)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			el.addChild(partial)
 
+		case '$':
+			// {{$super}}: render the overridden ancestor block here
+			el.addChild(&superElement{superType})
+
 		case '*':
 			// block element
 			name := string(bytes.TrimSpace(text[1:]))
+
+			if name == "super" {
+				// {{*super}}: alias for {{$super}}
+				el.addChild(&superElement{superType})
+				break
+			}
+
 			block := &blockElement{blockType, name, []element{}}
 
-			if err = tmpl.pareseContainer(block); err!=nil{
-				return err
+			if _, err = p.pareseContainer(block); err!=nil{
+				return nil, err
 			}
 
 			el.addChild(block)
-			tmpl.blocks[name] = block
+			p.blocks[name] = block
 
 		case '/':
 			// close element
 			name := string(bytes.TrimSpace(text[1:]))
 			if name != el.getName() {
-				return parseError{tmpl.line, "error closing tag: " + name}
+				return nil, p.parseErr(name, "error closing tag")
 			} else {
-				return nil
+				return p.data[start:tagStart], nil
 			}
 		default:
-			el.addChild(&variableElement{variableType, string(text), false})
+			name, fmts := parseVariableTag(text)
+			el.addChild(&variableElement{variableType, name, false, fmts})
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 
-func (tmpl *Template) parsePartial(name string) (*Template, error) {
-	filename := path.Join(tmpl.dir, name + tmpl.ext)
-	partial, err := ParseFile(filename)
+// parseVariableTag splits a {{name}} tag body into its variable name and
+// its optional formatter pipeline, e.g. "name|fmt1|fmt2".
+func parseVariableTag(text []byte) (string, []string) {
+	parts := strings.Split(string(text), "|")
+	name := strings.TrimSpace(parts[0])
+	if len(parts) == 1 {
+		return name, nil
+	}
+
+	formatters := make([]string, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		formatters = append(formatters, strings.TrimSpace(p))
+	}
+	return name, formatters
+}
+
+// parseDelims parses the body of a set-delimiter tag, e.g. the "=<% %>="
+// in "{{=<% %>=}}", and returns the new left and right delimiters.
+func parseDelims(text []byte) (string, string, error) {
+	text = bytes.TrimSpace(text)
+	if len(text) < 2 || text[0] != '=' || text[len(text)-1] != '=' {
+		return "", "", fmt.Errorf("invalid set-delimiter tag")
+	}
+
+	fields := strings.Fields(string(text[1 : len(text)-1]))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("set-delimiter tag must have exactly two delimiters")
+	}
+
+	return fields[0], fields[1], nil
+}
 
+func (p *parser) parsePartial(name string) (*Template, error) {
+	var t *Template
+	var err error
+	if p.set != nil {
+		t, err = p.set.ParseFile(name)
+	} else {
+		t, err = ParseFile(path.Join(p.dir, name + p.ext))
+	}
 	if err != nil {
-		return nil, err
+		switch err.(type) {
+		case *ParseError, *PartialNotFoundError:
+			// the file was found but malformed, or the lookup already
+			// reported a not-found error: pass either through unchanged
+			// instead of re-wrapping it
+			return nil, err
+		default:
+			return nil, &PartialNotFoundError{Name: name, Err: err}
+		}
 	}
-	return partial, nil
+	return t, nil
 }
 
+// defaultLeftToken and defaultRightToken are the standard mustache
+// delimiters, used unless overridden via ParseStringWithDelims or
+// TemplateSet.SetDelims.
+const (
+	defaultLeftToken = "{{"
+	defaultRightToken = "}}"
+)
+
+func newParser(data []byte, dir, ext, left, right, file string, strict bool, set *TemplateSet) *parser {
+	return &parser{
+		data: data,
+		leftToken: left,
+		rightToken: right,
+		line: 1,
+		col: 1,
+		file: file,
+		strict: strict,
+		dir: dir,
+		ext: ext,
+		set: set,
+		blocks: map[string]*blockElement{},
+	}
+}
 
 func ParseString(data string) (*Template, error) {
-	cwd := os.Getenv("CWD")
-	tmpl := Template{templateType, []byte(data), "{{", "}}", 0, 1, cwd, []element{}, map[string]*blockElement{}, "", ""}
-	err := tmpl.parse()
+	return ParseStringWithDelims(data, defaultLeftToken, defaultRightToken)
+}
 
-	if err != nil {
+// ParseStringWithDelims is like ParseString, but starts parsing with
+// left/right as the initial tag delimiters instead of "{{"/"}}". This
+// is for embedding mustache inside a host language that already uses
+// curly braces, e.g. left="<%", right="%>". A {{=NEW_LEFT NEW_RIGHT=}}
+// tag can still change the delimiters further on.
+func ParseStringWithDelims(data, left, right string) (*Template, error) {
+	p := newParser([]byte(data), os.Getenv("CWD"), "", left, right, "", false, nil)
+	if err := p.parse(); err != nil {
 		return nil, err
 	}
-
-	return &tmpl, err
+	return p.toTemplate(), nil
 }
 
 
@@ -537,14 +948,98 @@ func ParseFile(filename string) (*Template, error) {
 	dirname, fname := path.Split(filename)
 	ext := path.Ext(fname)
 
-	tmpl := Template{templateType, data, "{{", "}}", 0, 1, dirname, []element{}, map[string]*blockElement{}, ext, ""}
+	p := newParser(data, dirname, ext, defaultLeftToken, defaultRightToken, filename, false, nil)
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.toTemplate(), nil
+}
 
-	err = tmpl.parse()
+// TemplateSet owns a template directory and extension, and caches every
+// *Template parsed through it by name, so that {{<parent}} and
+// {{>partial}} lookups reuse an already-parsed Template instead of
+// reading and re-parsing the file on every render.
+type TemplateSet struct {
+	dir string
+	ext string
+	leftToken string
+	rightToken string
+	strict bool
+	mu sync.RWMutex
+	templates map[string]*Template
+}
+
+// NewSet creates a TemplateSet rooted at dir, whose members are looked
+// up by name with ext appended, e.g. NewSet("views", ".mustache").
+func NewSet(dir, ext string) *TemplateSet {
+	return &TemplateSet{dir: dir, ext: ext, leftToken: defaultLeftToken, rightToken: defaultRightToken, templates: map[string]*Template{}}
+}
+
+// SetDelims changes the tag delimiters every member of the set starts
+// parsing with, e.g. set.SetDelims("<%", "%>"). Call it before parsing
+// any template from the set.
+func (set *TemplateSet) SetDelims(left, right string) *TemplateSet {
+	set.leftToken = left
+	set.rightToken = right
+	return set
+}
+
+// Strict changes the default Template.Strict setting every member of
+// the set is parsed with. Call it before parsing any template from the
+// set.
+func (set *TemplateSet) Strict(strict bool) *TemplateSet {
+	set.strict = strict
+	return set
+}
+
+// ParseFile parses (or returns the cached) template called name within
+// the set.
+func (set *TemplateSet) ParseFile(name string) (*Template, error) {
+	set.mu.RLock()
+	tmpl, ok := set.templates[name]
+	set.mu.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	data, err := ioutil.ReadFile(path.Join(set.dir, name + set.ext))
 	if err != nil {
+		return nil, &PartialNotFoundError{Name: name, Err: err}
+	}
+
+	p := newParser(data, set.dir, set.ext, set.leftToken, set.rightToken, path.Join(set.dir, name + set.ext), set.strict, set)
+	if err := p.parse(); err != nil {
 		return nil, err
 	}
+	tmpl = p.toTemplate()
 
-	return &tmpl, nil
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if existing, ok := set.templates[name]; ok {
+		// lost the race to another goroutine parsing the same name
+		return existing, nil
+	}
+	set.templates[name] = tmpl
+	return tmpl, nil
+}
+
+// MustParse is like ParseFile but panics on error, for template sets
+// populated during program initialization.
+func (set *TemplateSet) MustParse(name string) *Template {
+	tmpl, err := set.ParseFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+// Lookup returns the already-parsed template called name, if any. It
+// never parses — use ParseFile to parse on demand.
+func (set *TemplateSet) Lookup(name string) (*Template, bool) {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	tmpl, ok := set.templates[name]
+	return tmpl, ok
 }
 
 func Render(data string, context ...interface{}) string {
@@ -569,13 +1064,64 @@ func RenderFile(filename string, context ...interface{}) string {
 //=============================================
 
 
-type parseError struct {
-	line    int
-	message string
+// ParseError reports a problem found while parsing a template: the
+// file it came from (empty when parsed from a string, e.g. via
+// ParseString), the 1-based line and column the parser had reached when
+// it detected the problem (just past the offending tag; for an unclosed
+// tag or section, where the scan ran out of input), the tag's raw text
+// where known, and a human-readable message.
+type ParseError struct {
+	File string
+	Line int
+	Col  int
+	Tag  string
+	Msg  string
 }
 
-func (p parseError) Error() string {
-	return fmt.Sprintf("line %d: %s", p.line, p.message)
+func (e *ParseError) Error() string {
+	where := fmt.Sprintf("%d:%d", e.Line, e.Col)
+	if e.File != "" {
+		where = e.File + ":" + where
+	}
+	if e.Tag != "" {
+		return fmt.Sprintf("%s: %s: %q", where, e.Msg, e.Tag)
+	}
+	return fmt.Sprintf("%s: %s", where, e.Msg)
+}
+
+// PartialNotFoundError reports that a {{>partial}} or {{<parent}} tag
+// named Name could not be resolved to a template, wrapping the
+// underlying lookup error (typically a file-not-found error).
+type PartialNotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *PartialNotFoundError) Error() string {
+	return fmt.Sprintf("partial %q not found: %s", e.Name, e.Err)
+}
+
+func (e *PartialNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// RenderError reports a problem encountered while rendering an already
+// parsed template, such as a {{<parent}} that can no longer be
+// resolved, or a Strict-mode lookup failure. Path holds one name: the
+// name whose Strict-mode lookup failed, or the {{<parent}} name declared
+// by the template whose parent chain could not be resolved. The names
+// of enclosing sections, blocks and partials are not recorded.
+type RenderError struct {
+	Path  []string
+	Cause error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s: %s", strings.Join(e.Path, " -> "), e.Cause)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Cause
 }
 
 
@@ -591,8 +1137,22 @@ func lookupAttr(name string, contextChain []interface{}) (reflect.Value) {
 			if n := v.Type().NumMethod(); n > 0 {
 				for i := 0; i < n; i++ {
 					m := t.Method(i)
-					if m.Name == name && m.Type.NumIn() == 1 {
-						return v.Method(i).Call(nil)[0]
+					if m.Name == name {
+						method := v.Method(i)
+						if isLambdaMethodType(method.Type()) {
+							// bound method matches one of the lambda
+							// signatures Render dispatches through
+							// callVarLambda/callSectionLambda: return it
+							// uncalled, same as a map-stored func value
+							return method
+						}
+						if method.Type().NumIn() == 0 {
+							// zero-arg method whose result isn't one of
+							// the lambda shapes (e.g. Age() int): call it
+							// now and use its actual return value
+							return method.Call(nil)[0]
+						}
+						return method
 					}
 				}
 			}
@@ -680,4 +1240,80 @@ func isTrue(val reflect.Value) bool {
 		return true // Struct values are always true.
 	}
 	return false
+}
+
+// renderLambdaOutput re-parses a lambda's return value as a mustache
+// fragment (using the default delimiters) and renders it against
+// contextChain, per the spec's requirement that lambda output be
+// interpolated as template content.
+func renderLambdaOutput(s string, contextChain []interface{}) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+
+	t, err := ParseString(s)
+	if err != nil {
+		return s
+	}
+
+	return t.RenderToString(contextChain...)
+}
+
+// isLambdaMethodType reports whether t is one of the lambda signatures
+// callVarLambda/callSectionLambda know how to dispatch: func() string,
+// func(string) string, or func(string, func(string) string) string. A
+// struct method matching one of these is routed through the lambda path
+// uncalled, same as a map-stored func value; lookupAttr calls any other
+// zero-arg method immediately and uses its real return value, and
+// returns a method that takes arguments uncalled.
+func isLambdaMethodType(t reflect.Type) bool {
+	switch t.NumIn() {
+	case 0:
+		return t.NumOut() == 1 && t.Out(0).Kind() == reflect.String
+	case 1:
+		return t.NumOut() == 1 && t.In(0).Kind() == reflect.String && t.Out(0).Kind() == reflect.String
+	case 2:
+		return t.NumOut() == 1 && t.In(0).Kind() == reflect.String &&
+			t.In(1).Kind() == reflect.Func && t.Out(0).Kind() == reflect.String
+	}
+	return false
+}
+
+// callVarLambda dispatches a {{name}} lookup that resolved to a func.
+// Only the zero-arg `func() string` signature is meaningful here; the
+// section-only signatures are handled by callSectionLambda.
+func callVarLambda(val reflect.Value, contextChain []interface{}) (string, bool) {
+	t := val.Type()
+	if t.NumIn() != 0 || t.NumOut() != 1 || t.Out(0).Kind() != reflect.String {
+		return "", false
+	}
+
+	out := val.Call(nil)[0].String()
+	return renderLambdaOutput(out, contextChain), true
+}
+
+// callSectionLambda dispatches a {{#name}}...{{/name}} lookup that
+// resolved to a func, following the two lambda signatures defined by
+// the mustache spec: a plain text transform, and a transform that also
+// receives a render callback for expanding tags itself.
+func callSectionLambda(val reflect.Value, text string, contextChain []interface{}) (string, bool) {
+	t := val.Type()
+
+	switch {
+	case t.NumIn() == 1 && t.NumOut() == 1 &&
+		t.In(0).Kind() == reflect.String && t.Out(0).Kind() == reflect.String:
+		out := val.Call([]reflect.Value{reflect.ValueOf(text)})[0].String()
+		return renderLambdaOutput(out, contextChain), true
+
+	case t.NumIn() == 2 && t.NumOut() == 1 &&
+		t.In(0).Kind() == reflect.String && t.In(1).Kind() == reflect.Func && t.Out(0).Kind() == reflect.String:
+		render := reflect.MakeFunc(t.In(1), func(args []reflect.Value) []reflect.Value {
+			out := renderLambdaOutput(args[0].String(), contextChain)
+			return []reflect.Value{reflect.ValueOf(out)}
+		})
+		out := val.Call([]reflect.Value{reflect.ValueOf(text), render})[0].String()
+		return renderLambdaOutput(out, contextChain), true
+	}
+
+	return "", false
 }
\ No newline at end of file
@@ -0,0 +1,329 @@
+package mustache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSetDelimiterBasic checks that a {{=<% %>=}} tag switches the
+// delimiters used for the rest of the template.
+func TestSetDelimiterBasic(t *testing.T) {
+	tmpl, err := ParseString("{{Greeting}}, {{=<% %>=}}<%Name%>!")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	ctx := map[string]interface{}{"Greeting": "Hi", "Name": "World"}
+	out := tmpl.RenderToString(ctx)
+	if out != "Hi, World!" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestSetDelimiterScopedToSection checks that delimiters changed inside
+// a section revert to whatever was active before the section once the
+// section's close tag is reached, per the package doc comment.
+func TestSetDelimiterScopedToSection(t *testing.T) {
+	tmpl, err := ParseString("{{#Section}}{{=<% %>=}}<%Name%><%/Section%>{{Name}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	ctx := map[string]interface{}{"Section": true, "Name": "X"}
+	out := tmpl.RenderToString(ctx)
+	if out != "XX" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestSetDelimiterInPartial checks that a delimiter change inside a
+// {{>partial}} is local to that partial's own parse and doesn't affect
+// how the including template parses the tags that follow the partial.
+func TestSetDelimiterInPartial(t *testing.T) {
+	dir := t.TempDir()
+	ext := ".mustache"
+
+	if err := ioutil.WriteFile(path.Join(dir, "partial"+ext), []byte("{{=<% %>=}}<%Name%>"), 0644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+
+	set := NewSet(dir, ext)
+	if err := ioutil.WriteFile(path.Join(dir, "main"+ext), []byte("{{>partial}}, {{Name}}!"), 0644); err != nil {
+		t.Fatalf("write main: %v", err)
+	}
+
+	tmpl, err := set.ParseFile("main")
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+
+	ctx := map[string]interface{}{"Name": "World"}
+	out := tmpl.RenderToString(ctx)
+	if out != "World, World!" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestVariableLambda checks that a zero-arg func() string stored in a
+// map context is invoked and its result re-parsed as a mustache
+// fragment, per the mustache spec's lambda rules.
+func TestVariableLambda(t *testing.T) {
+	tmpl, err := ParseString("{{Greeting}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	ctx := map[string]interface{}{
+		"Name":     "World",
+		"Greeting": func() string { return "Hello {{Name}}!" },
+	}
+	out := tmpl.RenderToString(ctx)
+	if out != "Hello World!" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestSectionLambda checks that a section whose name resolves to a
+// func(string) string is called with the section's raw inner text and
+// its result re-parsed, per the mustache spec's lambda rules.
+func TestSectionLambda(t *testing.T) {
+	tmpl, err := ParseString("{{#Wrapped}}abc{{Name}}def{{/Wrapped}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	ctx := map[string]interface{}{
+		"Name":    "X",
+		"Wrapped": func(text string) string { return "<" + text + ">" },
+	}
+	out := tmpl.RenderToString(ctx)
+	if out != "<abcXdef>" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+type lambdaPerson struct {
+	name string
+	age  int
+}
+
+func (p lambdaPerson) Greeting() string { return "Hello {{Name}}!" }
+func (p lambdaPerson) Name() string     { return p.name }
+func (p lambdaPerson) Age() int         { return p.age }
+
+// TestMethodLambdaAndPlainMethod checks that struct methods dispatch
+// consistently with map-stored funcs: a func() string method is treated
+// as a lambda, while a method returning any other type is called
+// immediately and its real result used.
+func TestMethodLambdaAndPlainMethod(t *testing.T) {
+	tmpl, err := ParseString("{{Greeting}} {{Age}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	out := tmpl.RenderToString(lambdaPerson{name: "World", age: 42})
+	if out != "Hello World! 42" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestFormatterPipeline checks that {{name|fmt}} runs the named
+// formatter instead of the default HTML escaping, and that a formatter
+// registered on the template with Funcs is found by name.
+func TestFormatterPipeline(t *testing.T) {
+	tmpl, err := ParseString("{{Raw}} {{Raw|str}} {{Raw|upper}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	tmpl.Funcs(FormatterMap{
+		"upper": func(w io.Writer, val interface{}) {
+			fmt.Fprint(w, strings.ToUpper(fmt.Sprint(val)))
+		},
+	})
+
+	ctx := map[string]interface{}{"Raw": "a&b"}
+	out := tmpl.RenderToString(ctx)
+	if out != "a&amp;b a&b A&B" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+// TestTemplateSetCaching checks that a TemplateSet parses each named
+// template only once, returning the cached *Template on later lookups.
+func TestTemplateSetCaching(t *testing.T) {
+	dir := t.TempDir()
+	ext := ".mustache"
+	if err := ioutil.WriteFile(path.Join(dir, "greet"+ext), []byte("Hi {{Name}}"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	set := NewSet(dir, ext)
+	first, err := set.ParseFile("greet")
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	second, err := set.ParseFile("greet")
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if first != second {
+		t.Fatal("expected the cached template to be reused")
+	}
+}
+
+// TestExecuteReturnsError checks that Execute surfaces a render error
+// instead of panicking, unlike RenderToString.
+func TestExecuteReturnsError(t *testing.T) {
+	tmpl, err := ParseString("{{Name}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	tmpl.Strict(true)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// TestTemplateRenderConcurrentSafe checks that a parsed *Template can be
+// rendered from multiple goroutines at once, since parsing leaves no
+// mutable state on the Template itself.
+func TestTemplateRenderConcurrentSafe(t *testing.T) {
+	tmpl, err := ParseString("Hi {{Name}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := tmpl.RenderToString(map[string]interface{}{"Name": "World"})
+			if out != "Hi World" {
+				t.Errorf("got %q", out)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestBlockInheritanceNLevel checks that {{<parent}} block overrides
+// chain across more than two levels, and that {{$super}} steps back
+// through that chain one ancestor at a time.
+func TestBlockInheritanceNLevel(t *testing.T) {
+	dir := t.TempDir()
+	ext := ".mustache"
+
+	files := map[string]string{
+		"grandparent": "[{{*content}}base{{/content}}]",
+		"parent":      "{{<grandparent}}{{*content}}parent>{{$super}}{{/content}}",
+		"child":       "{{<parent}}{{*content}}child>{{$super}}{{/content}}",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name+ext), []byte(body), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	set := NewSet(dir, ext)
+	tmpl, err := set.ParseFile("child")
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+
+	out := tmpl.RenderToString()
+	if want := "[child>parent>base]"; out != want {
+		t.Fatalf("got %q want %q", out, want)
+	}
+}
+
+// TestStrictModeVariableError checks that Strict mode turns a missing
+// variable into a RenderError instead of rendering nothing.
+func TestStrictModeVariableError(t *testing.T) {
+	tmpl, err := ParseString("{{Missing}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	tmpl.Strict(true)
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{})
+	var renderErr *RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("expected *RenderError, got %T: %v", err, err)
+	}
+}
+
+// TestStrictModeSectionError checks that Strict mode also turns a
+// missing section name into a RenderError, not just missing variables.
+func TestStrictModeSectionError(t *testing.T) {
+	tmpl, err := ParseString("{{#Missing}}x{{/Missing}}")
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	tmpl.Strict(true)
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{})
+	var renderErr *RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("expected *RenderError, got %T: %v", err, err)
+	}
+}
+
+// TestParseErrorUnclosedTag checks that a malformed template produces a
+// *ParseError with position info instead of panicking.
+func TestParseErrorUnclosedTag(t *testing.T) {
+	_, err := ParseString("{{#Section}}no close")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+}
+
+// TestPartialParseErrorNotWrapped checks that a {{>partial}} whose
+// target file exists but fails to parse surfaces a *ParseError, not a
+// *PartialNotFoundError: the file was found, it just doesn't parse.
+func TestPartialParseErrorNotWrapped(t *testing.T) {
+	dir := t.TempDir()
+	ext := ".mustache"
+	if err := ioutil.WriteFile(path.Join(dir, "broken"+ext), []byte("{{#Section}}no close"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "main"+ext), []byte("{{>broken}}"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	set := NewSet(dir, ext)
+	_, err := set.ParseFile("main")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError, got %T: %v", err, err)
+	}
+}
+
+// TestPartialNotFoundError checks that a {{>partial}} whose target file
+// doesn't exist produces a *PartialNotFoundError.
+func TestPartialNotFoundError(t *testing.T) {
+	dir := t.TempDir()
+	ext := ".mustache"
+	if err := ioutil.WriteFile(path.Join(dir, "main"+ext), []byte("{{>missing}}"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	set := NewSet(dir, ext)
+	_, err := set.ParseFile("main")
+	var notFound *PartialNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected *PartialNotFoundError, got %T: %v", err, err)
+	}
+}